@@ -0,0 +1,51 @@
+/*
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cloudfoundry
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// AppGUID extracts the application GUID carried by envelope, if the event
+// type identifies an application at all. It understands the event types
+// that reference an app: LogMessage and HttpStartStop.
+func AppGUID(envelope *events.Envelope) (string, bool) {
+	switch envelope.GetEventType() {
+	case events.Envelope_LogMessage:
+		if id := envelope.GetLogMessage().GetAppId(); id != "" {
+			return id, true
+		}
+	case events.Envelope_HttpStartStop:
+		if id := envelope.GetHttpStartStop().GetApplicationId(); id != nil {
+			return uuidToString(id), true
+		}
+	}
+	return "", false
+}
+
+// uuidToString renders a Loggregator events.UUID (two little-endian uint64
+// halves) as a canonical, dashed UUID string.
+func uuidToString(uuid *events.UUID) string {
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[0:8], uuid.GetLow())
+	binary.LittleEndian.PutUint64(b[8:16], uuid.GetHigh())
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
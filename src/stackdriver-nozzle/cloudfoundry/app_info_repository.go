@@ -17,13 +17,37 @@
 package cloudfoundry
 
 import (
-	"github.com/cloudfoundry-community/go-cfclient"
+	"log"
+	"math"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"golang.org/x/sync/singleflight"
 )
 
+// maxNegativeBackoff caps how far repeated CF errors can stretch the
+// negative cache TTL, so a GUID that starts resolving again isn't stuck in
+// backoff for an unreasonable amount of time.
+const maxNegativeBackoff = 8
+
+// metricsReportInterval throttles how often GetAppInfo logs a Metrics
+// snapshot, so high-volume firehose traffic doesn't spam the log on every
+// lookup.
+const metricsReportInterval = time.Minute
+
 type AppInfoRepository interface {
 	GetAppInfo(string) AppInfo
+
+	// Prewarm populates the cache for guids up front, e.g. at startup,
+	// coalescing duplicate lookups the same way GetAppInfo does.
+	Prewarm(guids []string)
+
+	// Metrics returns a point-in-time snapshot of repository activity for
+	// reporting on the heartbeat/telemetry path.
+	Metrics() AppInfoMetrics
 }
 
 type AppInfo struct {
@@ -35,64 +59,215 @@ type AppInfo struct {
 	LastQueried time.Time
 }
 
+// AppInfoMetrics is a point-in-time snapshot of AppInfoRepository activity.
+type AppInfoMetrics struct {
+	Hits         uint64
+	Misses       uint64
+	Coalesced    uint64
+	NegativeHits uint64
+	CFErrors     uint64
+}
+
 func NewAppInfoRepository(cfClient *cfclient.Client, appMetadataCachePeriod int) AppInfoRepository {
-	return &appInfoRepository{cfClient, map[string]AppInfo{}, appMetadataCachePeriod}
+	return &appInfoRepository{
+		cfClient:               cfClient,
+		cache:                  map[string]cacheEntry{},
+		appMetadataCachePeriod: appMetadataCachePeriod,
+	}
 }
 
 func NullAppInfoRepository() AppInfoRepository {
 	return &nullAppInfoRepository{}
 }
 
+// cacheEntry records the last resolution for a GUID, positive or negative,
+// so GetAppInfo can decide whether it's still within TTL without hitting CF.
+type cacheEntry struct {
+	appInfo     AppInfo
+	found       bool
+	lastQueried time.Time
+	failures    int
+}
+
+// cfAppClient is the subset of cfclient.Client's API that appInfoRepository
+// depends on, so tests can substitute a fake CF client instead of hitting a
+// real Cloud Foundry API.
+type cfAppClient interface {
+	AppByGuid(guid string) (cfclient.App, error)
+}
+
 type appInfoRepository struct {
-	cfClient               *cfclient.Client
-	cache                  map[string]AppInfo
+	cfClient               cfAppClient
 	appMetadataCachePeriod int
+
+	mu    sync.RWMutex
+	cache map[string]cacheEntry
+
+	group singleflight.Group
+
+	metrics      AppInfoMetrics
+	reportMu     sync.Mutex
+	lastReported time.Time
 }
 
 func (air *appInfoRepository) GetAppInfo(guid string) AppInfo {
-	// Handle cacheable configurations
-	if air.appMetadataCachePeriod != 0 {
-		appInfo, ok := air.cache[guid]
-
-		if ok {
-			if air.appMetadataCachePeriod > 0 {
-				metadataReadTime := appInfo.LastQueried
-				// elapsedTime is in seconds, time.Since returns a duration, so we need to convert to seconds
-				elapsedTime := time.Since(metadataReadTime).Seconds()
-				// adjust ttl to be 75-125% of requested value to help ensure cache evictions are spread out and the cf api doesn't get hit all at once
-				adjustedTtl := float64(air.appMetadataCachePeriod) * (0.75 + (rand.Float64() / 2.0))
-
-				if elapsedTime < adjustedTtl {
-					return appInfo
-				}
-			} else {
-				return appInfo
-			}
+	defer air.reportMetrics()
+
+	if entry, ok := air.cachedEntry(guid); ok {
+		if entry.found {
+			atomic.AddUint64(&air.metrics.Hits, 1)
+		} else {
+			atomic.AddUint64(&air.metrics.NegativeHits, 1)
 		}
+		return entry.appInfo
+	}
+
+	atomic.AddUint64(&air.metrics.Misses, 1)
+	return air.queryCoalesced(guid)
+}
+
+func (air *appInfoRepository) Prewarm(guids []string) {
+	var wg sync.WaitGroup
+	for _, guid := range guids {
+		guid := guid
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			air.GetAppInfo(guid)
+		}()
+	}
+	wg.Wait()
+}
+
+func (air *appInfoRepository) Metrics() AppInfoMetrics {
+	return AppInfoMetrics{
+		Hits:         atomic.LoadUint64(&air.metrics.Hits),
+		Misses:       atomic.LoadUint64(&air.metrics.Misses),
+		Coalesced:    atomic.LoadUint64(&air.metrics.Coalesced),
+		NegativeHits: atomic.LoadUint64(&air.metrics.NegativeHits),
+		CFErrors:     atomic.LoadUint64(&air.metrics.CFErrors),
+	}
+}
+
+// reportMetrics logs a Metrics snapshot at most once per
+// metricsReportInterval, so hit/miss/coalesced/negative-hit/CF-error
+// counts are visible on the existing log-based telemetry path without
+// needing a dedicated metrics endpoint.
+func (air *appInfoRepository) reportMetrics() {
+	air.reportMu.Lock()
+	if time.Since(air.lastReported) < metricsReportInterval {
+		air.reportMu.Unlock()
+		return
 	}
+	air.lastReported = time.Now()
+	air.reportMu.Unlock()
 
-	return air.QueryCfForMetadata(guid)
+	metrics := air.Metrics()
+	log.Printf("cloudfoundry: app info metrics: hits=%d misses=%d coalesced=%d negative_hits=%d cf_errors=%d",
+		metrics.Hits, metrics.Misses, metrics.Coalesced, metrics.NegativeHits, metrics.CFErrors)
+}
+
+// cachedEntry returns the cached entry for guid, if caching is enabled and
+// the entry hasn't aged past its TTL.
+func (air *appInfoRepository) cachedEntry(guid string) (cacheEntry, bool) {
+	if air.appMetadataCachePeriod == 0 {
+		return cacheEntry{}, false
+	}
+
+	air.mu.RLock()
+	entry, ok := air.cache[guid]
+	air.mu.RUnlock()
+	if !ok {
+		return cacheEntry{}, false
+	}
+
+	if air.appMetadataCachePeriod < 0 {
+		return entry, true
+	}
+
+	if time.Since(entry.lastQueried).Seconds() < air.ttl(entry) {
+		return entry, true
+	}
+	return cacheEntry{}, false
+}
+
+// ttl computes the adjusted TTL for entry: a jittered 75-125% of
+// appMetadataCachePeriod for a resolved app, or 10% of that, backed off
+// exponentially (capped at maxNegativeBackoff) on repeated CF errors, for
+// an unresolved one.
+func (air *appInfoRepository) ttl(entry cacheEntry) float64 {
+	base := float64(air.appMetadataCachePeriod)
+	if !entry.found {
+		base *= 0.10
+		if entry.failures > 1 {
+			backoff := math.Min(math.Pow(2, float64(entry.failures-1)), maxNegativeBackoff)
+			base *= backoff
+		}
+	}
+	return base * (0.75 + (rand.Float64() / 2.0))
+}
+
+// queryCoalesced calls QueryCfForMetadata, coalescing concurrent lookups
+// for the same guid into a single CF API call.
+func (air *appInfoRepository) queryCoalesced(guid string) AppInfo {
+	executed := false
+	result, _, shared := air.group.Do(guid, func() (interface{}, error) {
+		executed = true
+		return air.QueryCfForMetadata(guid), nil
+	})
+	// singleflight reports shared for the caller that ran the lookup too;
+	// only the callers that waited on it were coalesced.
+	if shared && !executed {
+		atomic.AddUint64(&air.metrics.Coalesced, 1)
+	}
+	return result.(AppInfo)
 }
 
 func (air *appInfoRepository) QueryCfForMetadata(guid string) AppInfo {
-	var appInfo AppInfo
 	app, err := air.cfClient.AppByGuid(guid)
-	if err == nil {
-		appInfo := AppInfo{
-			AppName:     app.Name,
-			SpaceGUID:   app.SpaceData.Entity.Guid,
-			SpaceName:   app.SpaceData.Entity.Name,
-			OrgGUID:     app.SpaceData.Entity.OrgData.Entity.Guid,
-			OrgName:     app.SpaceData.Entity.OrgData.Entity.Name,
-			LastQueried: time.Now(),
-		}
-		air.cache[guid] = appInfo
+	if err != nil {
+		atomic.AddUint64(&air.metrics.CFErrors, 1)
+		air.recordFailure(guid)
+		return AppInfo{}
 	}
+
+	appInfo := AppInfo{
+		AppName:     app.Name,
+		SpaceGUID:   app.SpaceData.Entity.Guid,
+		SpaceName:   app.SpaceData.Entity.Name,
+		OrgGUID:     app.SpaceData.Entity.OrgData.Entity.Guid,
+		OrgName:     app.SpaceData.Entity.OrgData.Entity.Name,
+		LastQueried: time.Now(),
+	}
+	air.store(guid, cacheEntry{appInfo: appInfo, found: true, lastQueried: appInfo.LastQueried})
 	return appInfo
 }
 
+func (air *appInfoRepository) recordFailure(guid string) {
+	air.mu.Lock()
+	defer air.mu.Unlock()
+	entry := air.cache[guid]
+	air.cache[guid] = cacheEntry{
+		found:       false,
+		lastQueried: time.Now(),
+		failures:    entry.failures + 1,
+	}
+}
+
+func (air *appInfoRepository) store(guid string, entry cacheEntry) {
+	air.mu.Lock()
+	defer air.mu.Unlock()
+	air.cache[guid] = entry
+}
+
 type nullAppInfoRepository struct{}
 
 func (nair *nullAppInfoRepository) GetAppInfo(guid string) AppInfo {
 	return AppInfo{}
 }
+
+func (nair *nullAppInfoRepository) Prewarm(guids []string) {}
+
+func (nair *nullAppInfoRepository) Metrics() AppInfoMetrics {
+	return AppInfoMetrics{}
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cloudfoundry
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeCFClient is a test double for cfAppClient. When release is non-nil,
+// AppByGuid blocks until it's closed, so tests can force concurrent
+// GetAppInfo calls to overlap and exercise singleflight coalescing.
+type fakeCFClient struct {
+	app     cfclient.App
+	err     error
+	release chan struct{}
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *fakeCFClient) AppByGuid(guid string) (cfclient.App, error) {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+
+	if c.release != nil {
+		<-c.release
+	}
+	return c.app, c.err
+}
+
+func (c *fakeCFClient) callCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+var _ = Describe("appInfoRepository", func() {
+	It("coalesces concurrent lookups for the same guid into a single CF call", func() {
+		release := make(chan struct{})
+		client := &fakeCFClient{app: cfclient.App{Name: "app"}, release: release}
+		air := &appInfoRepository{cfClient: client, cache: map[string]cacheEntry{}}
+
+		const concurrentCallers = 10
+		var wg sync.WaitGroup
+		wg.Add(concurrentCallers)
+		for i := 0; i < concurrentCallers; i++ {
+			go func() {
+				defer wg.Done()
+				air.GetAppInfo("guid")
+			}()
+		}
+
+		// Give the callers a chance to pile up behind the in-flight CF call
+		// before letting it complete.
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		Expect(client.callCount()).To(Equal(1))
+		Expect(air.Metrics().Coalesced).To(Equal(uint64(concurrentCallers - 1)))
+	})
+
+	It("negative-caches CF errors within the TTL", func() {
+		client := &fakeCFClient{err: errors.New("cf unavailable")}
+		air := &appInfoRepository{cfClient: client, cache: map[string]cacheEntry{}, appMetadataCachePeriod: 100}
+
+		air.GetAppInfo("guid")
+		air.GetAppInfo("guid")
+
+		Expect(client.callCount()).To(Equal(1))
+		Expect(air.Metrics().NegativeHits).To(Equal(uint64(1)))
+		Expect(air.Metrics().CFErrors).To(Equal(uint64(1)))
+	})
+
+	It("backs the negative TTL off exponentially on repeated failures, up to a cap", func() {
+		air := &appInfoRepository{appMetadataCachePeriod: 100}
+
+		ttlFewFailures := air.ttl(cacheEntry{found: false, failures: 1})
+		ttlMoreFailures := air.ttl(cacheEntry{found: false, failures: 3})
+		ttlManyFailures := air.ttl(cacheEntry{found: false, failures: 20})
+
+		Expect(ttlMoreFailures).To(BeNumerically(">", ttlFewFailures))
+
+		maxTTL := 100 * 0.10 * maxNegativeBackoff * 1.25
+		Expect(ttlManyFailures).To(BeNumerically("<=", maxTTL))
+	})
+
+	It("caches positive lookups", func() {
+		client := &fakeCFClient{app: cfclient.App{Name: "app"}}
+		air := &appInfoRepository{cfClient: client, cache: map[string]cacheEntry{}, appMetadataCachePeriod: 100}
+
+		first := air.GetAppInfo("guid")
+		second := air.GetAppInfo("guid")
+
+		Expect(client.callCount()).To(Equal(1))
+		Expect(first.AppName).To(Equal("app"))
+		Expect(second.AppName).To(Equal("app"))
+		Expect(air.Metrics().Hits).To(Equal(uint64(1)))
+	})
+})
@@ -0,0 +1,12 @@
+package cloudfoundry
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+)
+
+func TestCloudfoundry(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cloudfoundry Suite")
+}
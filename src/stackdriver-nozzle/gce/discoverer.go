@@ -0,0 +1,148 @@
+/*
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package gce auto-discovers the project, zone, and cluster this nozzle is
+// running in when deployed on GCE/GKE, modeled on the discovery done by
+// Google's prometheus-engine exporter.
+package gce
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+)
+
+// defaultTimeout bounds how long Discover will block probing the metadata
+// server, so a nozzle running off-GCE (or behind a firewall that drops the
+// request) still starts promptly.
+const defaultTimeout = 5 * time.Second
+
+// unknown marks a field Discover could not resolve, distinguishing "looked
+// and failed" from "never configured" in the labels it produces.
+const unknown = "unknown"
+
+// metadataClient is the subset of *metadata.Client (plus the OnGCE probe)
+// Discoverer depends on, pulled out so tests can substitute a fake.
+type metadataClient interface {
+	OnGCEWithContext(ctx context.Context) bool
+	ProjectIDWithContext(ctx context.Context) (string, error)
+	ZoneWithContext(ctx context.Context) (string, error)
+	InstanceAttributeValueWithContext(ctx context.Context, attr string) (string, error)
+}
+
+// Metadata is the GCE identity discovered for this nozzle instance.
+type Metadata struct {
+	OnGCE           bool
+	ProjectID       string
+	Zone            string
+	ClusterName     string
+	ClusterLocation string
+}
+
+// Labels renders m as the gce.* labels attached to metrics and log entries.
+// Off-GCE, or for fields that couldn't be resolved, it omits the label
+// entirely rather than reporting "unknown".
+func (m Metadata) Labels() map[string]string {
+	labels := map[string]string{}
+	if !m.OnGCE {
+		return labels
+	}
+	if m.ProjectID != "" && m.ProjectID != unknown {
+		labels["gce.project_id"] = m.ProjectID
+	}
+	if m.Zone != "" && m.Zone != unknown {
+		labels["gce.zone"] = m.Zone
+	}
+	if m.ClusterName != "" && m.ClusterName != unknown {
+		labels["gce.cluster_name"] = m.ClusterName
+	}
+	return labels
+}
+
+// Discoverer probes the GCE metadata server for this instance's identity.
+type Discoverer struct {
+	timeout time.Duration
+	client  metadataClient
+}
+
+// NewDiscoverer builds a Discoverer bounded by defaultTimeout.
+func NewDiscoverer() *Discoverer {
+	return newDiscoverer(defaultTimeout, gceClient{metadata.NewClient(http.DefaultClient)})
+}
+
+func newDiscoverer(timeout time.Duration, client metadataClient) *Discoverer {
+	return &Discoverer{timeout: timeout, client: client}
+}
+
+// Discover fetches project/zone/cluster-name/cluster-location in parallel,
+// first probing OnGCEWithContext to short-circuit off-GCE environments
+// without blocking on the other lookups. Any field that errors or doesn't
+// resolve within the timeout is left as "unknown" rather than propagating
+// an error, since discovery is best-effort: the operator can always set
+// these explicitly.
+func (d *Discoverer) Discover() Metadata {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	if !d.client.OnGCEWithContext(ctx) {
+		return Metadata{
+			ProjectID:       unknown,
+			Zone:            unknown,
+			ClusterName:     unknown,
+			ClusterLocation: unknown,
+		}
+	}
+
+	m := Metadata{OnGCE: true}
+
+	var wg sync.WaitGroup
+	fetch := func(dst *string, call func(context.Context) (string, error)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := call(ctx)
+			if err != nil {
+				value = unknown
+			}
+			*dst = value
+		}()
+	}
+
+	fetch(&m.ProjectID, d.client.ProjectIDWithContext)
+	fetch(&m.Zone, d.client.ZoneWithContext)
+	fetch(&m.ClusterName, func(ctx context.Context) (string, error) {
+		return d.client.InstanceAttributeValueWithContext(ctx, "cluster-name")
+	})
+	fetch(&m.ClusterLocation, func(ctx context.Context) (string, error) {
+		return d.client.InstanceAttributeValueWithContext(ctx, "cluster-location")
+	})
+	wg.Wait()
+
+	return m
+}
+
+// gceClient adapts *metadata.Client (and the package-level OnGCEWithContext
+// probe) to metadataClient.
+type gceClient struct {
+	*metadata.Client
+}
+
+func (c gceClient) OnGCEWithContext(ctx context.Context) bool {
+	return metadata.OnGCEWithContext(ctx)
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gce
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// hangingClient reports itself as running on GCE, but never answers any
+// other call, simulating a metadata server that's unreachable (e.g. behind
+// a firewall) rather than simply absent.
+type hangingClient struct{}
+
+func (hangingClient) OnGCEWithContext(ctx context.Context) bool { return true }
+
+func (hangingClient) block(ctx context.Context) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func (c hangingClient) ProjectIDWithContext(ctx context.Context) (string, error) {
+	return c.block(ctx)
+}
+
+func (c hangingClient) ZoneWithContext(ctx context.Context) (string, error) {
+	return c.block(ctx)
+}
+
+func (c hangingClient) InstanceAttributeValueWithContext(ctx context.Context, attr string) (string, error) {
+	return c.block(ctx)
+}
+
+// offGCEClient reports that it isn't running on GCE; any other call should
+// never be reached.
+type offGCEClient struct{ hangingClient }
+
+func (offGCEClient) OnGCEWithContext(ctx context.Context) bool { return false }
+
+var _ = Describe("Discoverer", func() {
+	It("bounds Discover to the configured timeout against an unreachable metadata server", func() {
+		d := newDiscoverer(50*time.Millisecond, hangingClient{})
+
+		start := time.Now()
+		m := d.Discover()
+		elapsed := time.Since(start)
+
+		Expect(elapsed).To(BeNumerically("<", time.Second))
+		Expect(m.OnGCE).To(BeTrue())
+		Expect(m.ProjectID).To(Equal(unknown))
+		Expect(m.Zone).To(Equal(unknown))
+		Expect(m.ClusterName).To(Equal(unknown))
+		Expect(m.Labels()).To(BeEmpty())
+	})
+
+	It("short-circuits off-GCE without calling the other lookups", func() {
+		d := newDiscoverer(defaultTimeout, offGCEClient{})
+
+		m := d.Discover()
+
+		Expect(m.OnGCE).To(BeFalse())
+		Expect(m.Labels()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,12 @@
+package gce
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+)
+
+func TestGce(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Gce Suite")
+}
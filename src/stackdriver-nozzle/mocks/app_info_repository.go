@@ -0,0 +1,35 @@
+/*
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mocks
+
+import "github.com/cloudfoundry-community/stackdriver-tools/src/stackdriver-nozzle/cloudfoundry"
+
+// AppInfoRepository is a test double for cloudfoundry.AppInfoRepository
+// backed by a plain map, so tests can seed resolved apps without a CF API.
+type AppInfoRepository struct {
+	AppInfoMap map[string]cloudfoundry.AppInfo
+}
+
+func (m *AppInfoRepository) GetAppInfo(guid string) cloudfoundry.AppInfo {
+	return m.AppInfoMap[guid]
+}
+
+func (m *AppInfoRepository) Prewarm(guids []string) {}
+
+func (m *AppInfoRepository) Metrics() cloudfoundry.AppInfoMetrics {
+	return cloudfoundry.AppInfoMetrics{}
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nozzle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudfoundry-community/stackdriver-tools/src/stackdriver-nozzle/cloudfoundry"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/golang/protobuf/jsonpb"
+)
+
+// CloudEventSink publishes Cloud Foundry envelopes as CloudEvents, e.g. into
+// a Knative/Eventing pipeline.
+type CloudEventSink interface {
+	Publish(envelope *events.Envelope) error
+}
+
+// CloudEventSinkConfig configures how envelopes are wrapped as CloudEvents
+// and where the resulting events are published.
+type CloudEventSinkConfig struct {
+	Foundation string
+	Deployment string
+
+	// Transport selects the protocol binding used to publish events:
+	// "http" or "pubsub".
+	Transport string
+
+	// HTTPTarget is the endpoint events are POSTed to when Transport is "http".
+	HTTPTarget string
+
+	// PubSubProject and PubSubTopic configure the Pub/Sub binding when
+	// Transport is "pubsub".
+	PubSubProject string
+	PubSubTopic   string
+
+	// AppInfoRepository resolves the application path used to populate the
+	// CloudEvent subject. It is optional; when nil, events are published
+	// without a subject.
+	AppInfoRepository cloudfoundry.AppInfoRepository
+}
+
+type cloudEventSink struct {
+	config CloudEventSinkConfig
+	client cloudevents.Client
+}
+
+// NewCloudEventSink builds a CloudEventSink from config, selecting an HTTP or
+// Pub/Sub protocol binding based on config.Transport.
+func NewCloudEventSink(config CloudEventSinkConfig) (CloudEventSink, error) {
+	var (
+		client cloudevents.Client
+		err    error
+	)
+
+	switch config.Transport {
+	case "http":
+		client, err = cloudevents.NewClientHTTP(cloudevents.WithTarget(config.HTTPTarget))
+	case "pubsub":
+		client, err = cloudevents.NewClientPubSub(context.Background(), config.PubSubProject, config.PubSubTopic)
+	default:
+		return nil, fmt.Errorf("cloudevents: unsupported transport %q", config.Transport)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cloudevents: building %s client: %v", config.Transport, err)
+	}
+
+	return &cloudEventSink{config: config, client: client}, nil
+}
+
+// Publish wraps envelope as a CloudEvent and sends it via the configured
+// transport.
+func (s *cloudEventSink) Publish(envelope *events.Envelope) error {
+	event, err := s.toCloudEvent(envelope)
+	if err != nil {
+		return fmt.Errorf("cloudevents: building event: %v", err)
+	}
+
+	result := s.client.Send(context.Background(), event)
+	if cloudevents.IsUndelivered(result) {
+		return fmt.Errorf("cloudevents: publish failed: %v", result)
+	}
+	return nil
+}
+
+func (s *cloudEventSink) toCloudEvent(envelope *events.Envelope) (cloudevents.Event, error) {
+	event := cloudevents.NewEvent()
+
+	event.SetID(ceID(envelope))
+	event.SetSource(fmt.Sprintf("//cloudfoundry.org/%s/%s/%s", s.config.Foundation, s.config.Deployment, envelope.GetJob()))
+	event.SetType(fmt.Sprintf("org.cloudfoundry.%s", envelope.GetEventType().String()))
+	event.SetTime(time.Unix(0, envelope.GetTimestamp()))
+
+	if subject, ok := s.subject(envelope); ok {
+		event.SetSubject(subject)
+	}
+	for key, value := range s.extensions(envelope) {
+		event.SetExtension(key, value)
+	}
+
+	data, err := (&jsonpb.Marshaler{}).MarshalToString(envelope)
+	if err != nil {
+		return cloudevents.Event{}, err
+	}
+	if err := event.SetData(cloudevents.ApplicationJSON, []byte(data)); err != nil {
+		return cloudevents.Event{}, err
+	}
+
+	return event, nil
+}
+
+// subject resolves the CloudEvent subject to the envelope's application
+// path, when the app can be identified and resolved.
+func (s *cloudEventSink) subject(envelope *events.Envelope) (string, bool) {
+	if s.config.AppInfoRepository == nil {
+		return "", false
+	}
+	guid, ok := cloudfoundry.AppGUID(envelope)
+	if !ok {
+		return "", false
+	}
+	appInfo := s.config.AppInfoRepository.GetAppInfo(guid)
+	if appInfo.AppName == "" {
+		return "", false
+	}
+	return applicationPath(appInfo), true
+}
+
+func (s *cloudEventSink) extensions(envelope *events.Envelope) map[string]interface{} {
+	extensions := map[string]interface{}{}
+
+	if guid, ok := cloudfoundry.AppGUID(envelope); ok {
+		extensions["appguid"] = guid
+		if s.config.AppInfoRepository != nil {
+			appInfo := s.config.AppInfoRepository.GetAppInfo(guid)
+			if appInfo.OrgGUID != "" {
+				extensions["orgguid"] = appInfo.OrgGUID
+			}
+			if appInfo.SpaceGUID != "" {
+				extensions["spaceguid"] = appInfo.SpaceGUID
+			}
+		}
+	}
+
+	if instanceIndex, ok := instanceIndex(envelope); ok {
+		extensions["instanceindex"] = instanceIndex
+	}
+
+	return extensions
+}
+
+// ceID derives a stable, unique CloudEvent id from the fields that
+// Loggregator guarantees are set on every envelope.
+func ceID(envelope *events.Envelope) string {
+	return fmt.Sprintf("%s-%d-%s", envelope.GetOrigin(), envelope.GetTimestamp(), envelope.GetIndex())
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nozzle
+
+import (
+	"strconv"
+
+	"github.com/cloudfoundry-community/stackdriver-tools/src/stackdriver-nozzle/cloudfoundry"
+	"github.com/cloudfoundry-community/stackdriver-tools/src/stackdriver-nozzle/mocks"
+	"github.com/cloudfoundry/sonde-go/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("cloudEventSink", func() {
+	var (
+		logEventType = events.Envelope_LogMessage
+		appGUID      = "app-guid"
+		subject      *cloudEventSink
+	)
+
+	BeforeEach(func() {
+		subject = &cloudEventSink{
+			config: CloudEventSinkConfig{
+				Foundation: "my-foundation",
+				Deployment: "my-deployment",
+				AppInfoRepository: &mocks.AppInfoRepository{
+					AppInfoMap: map[string]cloudfoundry.AppInfo{
+						appGUID: {
+							AppName:   "my-app",
+							SpaceName: "my-space",
+							OrgName:   "my-org",
+							SpaceGUID: "space-guid",
+							OrgGUID:   "org-guid",
+						},
+					},
+				},
+			},
+		}
+	})
+
+	envelopeWithApp := func(instanceIndex int32) *events.Envelope {
+		logMessage := &events.LogMessage{
+			AppId:          strPtr(appGUID),
+			SourceInstance: strPtr(strconv.Itoa(int(instanceIndex))),
+		}
+		origin := "origin"
+		job := "job"
+		timestamp := int64(1500000000000000000)
+		return &events.Envelope{
+			EventType:  &logEventType,
+			Origin:     &origin,
+			Job:        &job,
+			Timestamp:  &timestamp,
+			LogMessage: logMessage,
+		}
+	}
+
+	It("maps the envelope's source, type, id, and subject", func() {
+		event, err := subject.toCloudEvent(envelopeWithApp(0))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(event.Source()).To(Equal("//cloudfoundry.org/my-foundation/my-deployment/job"))
+		Expect(event.Type()).To(Equal("org.cloudfoundry.LogMessage"))
+		Expect(event.ID()).NotTo(BeEmpty())
+		Expect(event.Subject()).To(Equal("/my-org/my-space/my-app"))
+	})
+
+	It("sets appguid/orgguid/spaceguid/instanceindex extensions when the app resolves", func() {
+		event, err := subject.toCloudEvent(envelopeWithApp(3))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(event.Extensions()).To(HaveKeyWithValue("appguid", appGUID))
+		Expect(event.Extensions()).To(HaveKeyWithValue("orgguid", "org-guid"))
+		Expect(event.Extensions()).To(HaveKeyWithValue("spaceguid", "space-guid"))
+		Expect(event.Extensions()).To(HaveKeyWithValue("instanceindex", "3"))
+	})
+
+	It("omits the subject and org/space extensions when the app doesn't resolve", func() {
+		origin := "origin"
+		job := "job"
+		envelope := &events.Envelope{
+			EventType: &logEventType,
+			Origin:    &origin,
+			Job:       &job,
+			LogMessage: &events.LogMessage{
+				AppId: strPtr("unknown-guid"),
+			},
+		}
+
+		event, err := subject.toCloudEvent(envelope)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(event.Subject()).To(BeEmpty())
+		Expect(event.Extensions()).NotTo(HaveKey("orgguid"))
+	})
+
+	It("is deterministic for the same envelope", func() {
+		envelope := envelopeWithApp(0)
+
+		first, err := subject.toCloudEvent(envelope)
+		Expect(err).NotTo(HaveOccurred())
+		second, err := subject.toCloudEvent(envelope)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(first.ID()).To(Equal(second.ID()))
+		Expect(first.Subject()).To(Equal(second.Subject()))
+	})
+})
@@ -0,0 +1,220 @@
+/*
+ * Copyright 2017 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nozzle
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry-community/stackdriver-tools/src/stackdriver-nozzle/cloudfoundry"
+	"github.com/cloudfoundry-community/stackdriver-tools/src/stackdriver-nozzle/gce"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// promotedTagLabels maps the well-known Loggregator/Diego tag keys to the
+// first-class, namespaced labels they're promoted to when a LabelMaker is
+// configured to emit structured labels.
+var promotedTagLabels = map[string]string{
+	"app_id":            "application.id",
+	"app_name":          "application.name",
+	"space_id":          "space.id",
+	"space_name":        "space.name",
+	"organization_id":   "org.id",
+	"organization_name": "org.name",
+	"source_id":         "source.id",
+	"instance_id":       "instance.id",
+	"product":           "product",
+	"process_type":      "process.type",
+	"process_id":        "process.id",
+}
+
+// LabelMaker builds the Stackdriver labels attached to metrics and log
+// entries derived from a Cloud Foundry envelope.
+type LabelMaker interface {
+	// MetricLabels returns the labels for envelope. When skipAppMetadata is
+	// true, the (potentially expensive) app metadata lookup is skipped.
+	MetricLabels(envelope *events.Envelope, skipAppMetadata bool) map[string]string
+	LogLabels(envelope *events.Envelope) map[string]string
+}
+
+// NewLabelMaker builds a LabelMaker that flattens envelope tags into the
+// legacy `tags=k=v,k=v` label.
+func NewLabelMaker(appInfoRepository cloudfoundry.AppInfoRepository, foundation string) LabelMaker {
+	return NewLabelMakerWithSchema(appInfoRepository, foundation, false)
+}
+
+// NewLabelMakerWithSchema builds a LabelMaker. When structuredLabels is
+// true, well-known tags are promoted to first-class, namespaced labels
+// (see promotedTagLabels) instead of being flattened into `tags=k=v,k=v`.
+func NewLabelMakerWithSchema(appInfoRepository cloudfoundry.AppInfoRepository, foundation string, structuredLabels bool) LabelMaker {
+	return NewLabelMakerWithGCE(appInfoRepository, foundation, structuredLabels, gce.Metadata{})
+}
+
+// NewLabelMakerWithGCE builds a LabelMaker that additionally attaches the
+// gce.project_id/gce.zone/gce.cluster_name labels from gceMetadata, so
+// nozzles deployed on GKE/GCE self-identify without extra config.
+func NewLabelMakerWithGCE(appInfoRepository cloudfoundry.AppInfoRepository, foundation string, structuredLabels bool, gceMetadata gce.Metadata) LabelMaker {
+	return &labelMaker{
+		appInfoRepository: appInfoRepository,
+		foundation:        foundation,
+		structuredLabels:  structuredLabels,
+		gceLabels:         gceMetadata.Labels(),
+	}
+}
+
+type labelMaker struct {
+	appInfoRepository cloudfoundry.AppInfoRepository
+	foundation        string
+	structuredLabels  bool
+	gceLabels         map[string]string
+}
+
+func (l *labelMaker) MetricLabels(envelope *events.Envelope, skipAppMetadata bool) map[string]string {
+	labels := l.commonLabels(envelope)
+	if !skipAppMetadata {
+		l.addAppMetadata(envelope, labels)
+	}
+	return labels
+}
+
+func (l *labelMaker) LogLabels(envelope *events.Envelope) map[string]string {
+	labels := l.commonLabels(envelope)
+	labels["origin"] = envelope.GetOrigin()
+	labels["eventType"] = envelope.GetEventType().String()
+	l.addAppMetadata(envelope, labels)
+	return labels
+}
+
+func (l *labelMaker) commonLabels(envelope *events.Envelope) map[string]string {
+	labels := map[string]string{
+		"foundation": l.foundation,
+	}
+	if job := envelope.GetJob(); job != "" {
+		labels["job"] = job
+	}
+	if index := envelope.GetIndex(); index != "" {
+		labels["index"] = index
+	}
+	for key, value := range l.tagLabels(envelope.GetTags()) {
+		labels[key] = value
+	}
+	for key, value := range l.gceLabels {
+		labels[key] = value
+	}
+	return labels
+}
+
+// tagLabels renders envelope tags either as the legacy flattened `tags`
+// value, or as promoted/namespaced labels when structuredLabels is enabled.
+func (l *labelMaker) tagLabels(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return map[string]string{}
+	}
+
+	if !l.structuredLabels {
+		return map[string]string{"tags": flattenTags(tags)}
+	}
+
+	labels := make(map[string]string, len(tags))
+	for key, value := range tags {
+		if label, ok := promotedTagLabels[key]; ok {
+			labels[label] = value
+			continue
+		}
+		labels[fmt.Sprintf("tag.%s", dedot(key))] = value
+	}
+	return labels
+}
+
+// flattenTags renders tags as a single, deterministically-ordered
+// `k=v,k=v` string.
+func flattenTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, tags[key]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// dedot replaces '.' with '_' so a tag key can't collide with, or violate
+// the character restrictions of, a Stackdriver label name.
+func dedot(key string) string {
+	return strings.Replace(key, ".", "_", -1)
+}
+
+// addAppMetadata resolves the app identified by envelope, when possible,
+// and layers its fields over labels. Resolved fields take precedence over
+// any promoted tag of the same name, since they come from an authoritative
+// CF API lookup rather than the (possibly stale) tag the envelope shipped
+// with.
+func (l *labelMaker) addAppMetadata(envelope *events.Envelope, labels map[string]string) {
+	guid, ok := cloudfoundry.AppGUID(envelope)
+	if !ok {
+		return
+	}
+
+	appInfo := l.appInfoRepository.GetAppInfo(guid)
+	if appInfo.AppName == "" {
+		return
+	}
+
+	labels["applicationPath"] = applicationPath(appInfo)
+	if instanceIndex, ok := instanceIndex(envelope); ok {
+		labels["instanceIndex"] = instanceIndex
+	}
+
+	if l.structuredLabels {
+		labels["application.id"] = guid
+		labels["application.name"] = appInfo.AppName
+		labels["space.id"] = appInfo.SpaceGUID
+		labels["space.name"] = appInfo.SpaceName
+		labels["org.id"] = appInfo.OrgGUID
+		labels["org.name"] = appInfo.OrgName
+	}
+}
+
+// applicationPath renders appInfo as the canonical "/org/space/app" path
+// used both for the applicationPath label and the CloudEvent subject.
+func applicationPath(appInfo cloudfoundry.AppInfo) string {
+	return fmt.Sprintf("/%s/%s/%s", appInfo.OrgName, appInfo.SpaceName, appInfo.AppName)
+}
+
+func instanceIndex(envelope *events.Envelope) (string, bool) {
+	switch envelope.GetEventType() {
+	case events.Envelope_HttpStartStop:
+		httpStartStop := envelope.GetHttpStartStop()
+		if httpStartStop.InstanceIndex != nil {
+			return strconv.Itoa(int(httpStartStop.GetInstanceIndex())), true
+		}
+		if id := httpStartStop.GetInstanceId(); id != "" {
+			return id, true
+		}
+	case events.Envelope_LogMessage:
+		if id := envelope.GetLogMessage().GetSourceInstance(); id != "" {
+			return id, true
+		}
+	}
+	return "", false
+}
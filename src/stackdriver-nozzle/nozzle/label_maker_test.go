@@ -245,5 +245,83 @@ var _ = Describe("LabelMaker", func() {
 				})
 			})
 		})
+
+		Context("structured labels", func() {
+			var appInfoRepository *mocks.AppInfoRepository
+
+			BeforeEach(func() {
+				appInfoRepository = &mocks.AppInfoRepository{
+					AppInfoMap: map[string]cloudfoundry.AppInfo{},
+				}
+				subject = NewLabelMakerWithSchema(appInfoRepository, foundation, true)
+			})
+
+			It("promotes well-known tags to namespaced labels", func() {
+				eventType := events.Envelope_ValueMetric
+				tags := map[string]string{
+					"app_id":            "app-guid",
+					"app_name":          "my-app",
+					"space_id":          "space-guid",
+					"organization_name": "my-org",
+				}
+				envelope := &events.Envelope{
+					EventType: &eventType,
+					Tags:      tags,
+				}
+
+				labels := subject.MetricLabels(envelope, false)
+
+				Expect(labels).To(HaveKeyWithValue("application.id", "app-guid"))
+				Expect(labels).To(HaveKeyWithValue("application.name", "my-app"))
+				Expect(labels).To(HaveKeyWithValue("space.id", "space-guid"))
+				Expect(labels).To(HaveKeyWithValue("org.name", "my-org"))
+				Expect(labels).NotTo(HaveKey("tags"))
+			})
+
+			It("dedots unknown tag keys under a tag. prefix", func() {
+				eventType := events.Envelope_ValueMetric
+				tags := map[string]string{
+					"io.kubernetes.pod.name": "my-pod",
+				}
+				envelope := &events.Envelope{
+					EventType: &eventType,
+					Tags:      tags,
+				}
+
+				labels := subject.MetricLabels(envelope, false)
+
+				Expect(labels).To(HaveKeyWithValue("tag.io_kubernetes_pod_name", "my-pod"))
+			})
+
+			It("prefers resolved app metadata over a disagreeing tag", func() {
+				event := &events.LogMessage{
+					AppId:          &appGUID,
+					SourceInstance: strPtr("0"),
+				}
+				eventType := events.Envelope_LogMessage
+				envelope := &events.Envelope{
+					EventType:  &eventType,
+					LogMessage: event,
+					Tags: map[string]string{
+						"app_name":          "stale-name-from-tag",
+						"organization_name": "stale-org-from-tag",
+					},
+				}
+
+				appInfoRepository.AppInfoMap[appGUID] = cloudfoundry.AppInfo{
+					AppName: "resolved-name",
+					OrgName: "resolved-org",
+				}
+
+				labels := subject.MetricLabels(envelope, false)
+
+				Expect(labels).To(HaveKeyWithValue("application.name", "resolved-name"))
+				Expect(labels).To(HaveKeyWithValue("org.name", "resolved-org"))
+			})
+		})
 	})
 })
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -1,11 +1,12 @@
 package nozzle
 
 import (
+	"log"
 	"strings"
 
-	"github.com/cloudfoundry-community/gcp-tools-release/src/stackdriver-nozzle/heartbeat"
-	"github.com/cloudfoundry-community/gcp-tools-release/src/stackdriver-nozzle/serializer"
-	"github.com/cloudfoundry-community/gcp-tools-release/src/stackdriver-nozzle/stackdriver"
+	"github.com/cloudfoundry-community/stackdriver-tools/src/stackdriver-nozzle/heartbeat"
+	"github.com/cloudfoundry-community/stackdriver-tools/src/stackdriver-nozzle/serializer"
+	"github.com/cloudfoundry-community/stackdriver-tools/src/stackdriver-nozzle/stackdriver"
 	"github.com/cloudfoundry/sonde-go/events"
 )
 
@@ -22,16 +23,41 @@ func (e *PostMetricError) Error() string {
 }
 
 type Nozzle struct {
-	LogHandler    LogHandler
-	MetricAdapter stackdriver.MetricAdapter
-	Serializer    serializer.Serializer
-	Heartbeater   heartbeat.Heartbeater
+	LogHandler     LogHandler
+	MetricAdapter  stackdriver.MetricAdapter
+	Serializer     serializer.Serializer
+	Heartbeater    heartbeat.Heartbeater
+	CloudEventSink CloudEventSink
+
+	// Pipeline, when set, is evaluated before every envelope is serialized:
+	// it can drop the envelope outright, or direct it to a named Sink in
+	// place of the Nozzle's default LogHandler/MetricAdapter/CloudEventSink.
+	Pipeline *PipelineHolder
 }
 
 func (n *Nozzle) HandleEvent(envelope *events.Envelope) error {
+	sink, allowed := n.resolveSink(envelope)
+	if !allowed {
+		return nil
+	}
+
+	logHandler, metricAdapter, cloudEventSink := n.LogHandler, n.MetricAdapter, n.CloudEventSink
+	if sink != nil {
+		if sink.LogHandler != nil {
+			logHandler = sink.LogHandler
+		}
+		if sink.MetricAdapter != nil {
+			metricAdapter = sink.MetricAdapter
+		}
+		if sink.CloudEventSink != nil {
+			cloudEventSink = sink.CloudEventSink
+		}
+	}
+
 	if n.Serializer.IsLog(envelope) {
 		n.Heartbeater.AddCounter()
-		n.LogHandler.HandleEnvelope(envelope)
+		logHandler.HandleEnvelope(envelope)
+		publishCloudEvent(cloudEventSink, envelope)
 		return nil
 	} else {
 		metrics, err := n.Serializer.GetMetrics(envelope)
@@ -39,6 +65,37 @@ func (n *Nozzle) HandleEvent(envelope *events.Envelope) error {
 			return err
 		}
 		n.Heartbeater.AddCounter()
-		return n.MetricAdapter.PostMetrics(metrics)
+		publishCloudEvent(cloudEventSink, envelope)
+		return metricAdapter.PostMetrics(metrics)
+	}
+}
+
+// resolveSink runs envelope through the configured Pipeline, if any. It
+// returns allowed=false when a filter dropped the envelope. A nil Sink
+// means no router matched, so the Nozzle's defaults should be used.
+func (n *Nozzle) resolveSink(envelope *events.Envelope) (sink *Sink, allowed bool) {
+	if n.Pipeline == nil {
+		return nil, true
+	}
+
+	matched, allowed := n.Pipeline.Current().Evaluate(envelope)
+	if !allowed {
+		return nil, false
+	}
+	if matched.isZero() {
+		return nil, true
+	}
+	return &matched, true
+}
+
+// publishCloudEvent forwards a copy of envelope to sink, when one is
+// configured. A publish failure does not fail the surrounding log/metric
+// handling.
+func publishCloudEvent(sink CloudEventSink, envelope *events.Envelope) {
+	if sink == nil {
+		return
+	}
+	if err := sink.Publish(envelope); err != nil {
+		log.Printf("nozzle: publishing CloudEvent: %v", err)
 	}
 }
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nozzle
+
+import (
+	"github.com/cloudfoundry/sonde-go/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeHeartbeater struct {
+	count int
+}
+
+func (h *fakeHeartbeater) AddCounter() { h.count++ }
+
+type fakeSerializer struct {
+	isLog bool
+}
+
+func (s *fakeSerializer) IsLog(envelope *events.Envelope) bool { return s.isLog }
+
+func (s *fakeSerializer) GetMetrics(envelope *events.Envelope) (interface{}, error) {
+	return nil, nil
+}
+
+type recordingLogHandler struct {
+	envelopes []*events.Envelope
+}
+
+func (h *recordingLogHandler) HandleEnvelope(envelope *events.Envelope) {
+	h.envelopes = append(h.envelopes, envelope)
+}
+
+var _ = Describe("Nozzle.HandleEvent", func() {
+	var (
+		logEventType = events.Envelope_LogMessage
+		heartbeater  *fakeHeartbeater
+		defaultLog   *recordingLogHandler
+	)
+
+	BeforeEach(func() {
+		heartbeater = &fakeHeartbeater{}
+		defaultLog = &recordingLogHandler{}
+	})
+
+	envelopeFrom := func(origin string) *events.Envelope {
+		return &events.Envelope{EventType: &logEventType, Origin: &origin}
+	}
+
+	It("drops an envelope a filter rejects, without reaching any handler", func() {
+		pipeline := NewPipeline(
+			[]EnvelopeFilter{RuleFilter{Rule: Rule{Origins: []string{"allowed-origin"}}}},
+			nil,
+			nil,
+		)
+		n := &Nozzle{
+			LogHandler:  defaultLog,
+			Serializer:  &fakeSerializer{isLog: true},
+			Heartbeater: heartbeater,
+			Pipeline:    NewPipelineHolder(pipeline),
+		}
+
+		err := n.HandleEvent(envelopeFrom("other-origin"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(defaultLog.envelopes).To(BeEmpty())
+		Expect(heartbeater.count).To(Equal(0))
+		Expect(pipeline.Metrics().Dropped).To(Equal(uint64(1)))
+	})
+
+	It("routes an envelope a router matches to the named sink's LogHandler", func() {
+		routedLog := &recordingLogHandler{}
+		pipeline := NewPipeline(
+			nil,
+			[]EnvelopeRouter{RuleRouter{Rule: Rule{Origins: []string{"special-origin"}}, SinkName: "special"}},
+			map[string]Sink{"special": {LogHandler: routedLog}},
+		)
+		n := &Nozzle{
+			LogHandler:  defaultLog,
+			Serializer:  &fakeSerializer{isLog: true},
+			Heartbeater: heartbeater,
+			Pipeline:    NewPipelineHolder(pipeline),
+		}
+
+		envelope := envelopeFrom("special-origin")
+		err := n.HandleEvent(envelope)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(routedLog.envelopes).To(Equal([]*events.Envelope{envelope}))
+		Expect(defaultLog.envelopes).To(BeEmpty())
+		Expect(heartbeater.count).To(Equal(1))
+	})
+
+	It("falls through to the Nozzle's default LogHandler when no router matches", func() {
+		pipeline := NewPipeline(
+			nil,
+			[]EnvelopeRouter{RuleRouter{Rule: Rule{Origins: []string{"special-origin"}}, SinkName: "special"}},
+			map[string]Sink{"special": {}},
+		)
+		n := &Nozzle{
+			LogHandler:  defaultLog,
+			Serializer:  &fakeSerializer{isLog: true},
+			Heartbeater: heartbeater,
+			Pipeline:    NewPipelineHolder(pipeline),
+		}
+
+		envelope := envelopeFrom("unmatched-origin")
+		err := n.HandleEvent(envelope)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(defaultLog.envelopes).To(Equal([]*events.Envelope{envelope}))
+		Expect(heartbeater.count).To(Equal(1))
+	})
+
+	It("uses the Nozzle's defaults when no Pipeline is configured", func() {
+		n := &Nozzle{
+			LogHandler:  defaultLog,
+			Serializer:  &fakeSerializer{isLog: true},
+			Heartbeater: heartbeater,
+		}
+
+		envelope := envelopeFrom("any-origin")
+		err := n.HandleEvent(envelope)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(defaultLog.envelopes).To(Equal([]*events.Envelope{envelope}))
+		Expect(heartbeater.count).To(Equal(1))
+	})
+})
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nozzle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RuleConfig is the YAML representation of a Rule.
+type RuleConfig struct {
+	EventTypes  []string          `yaml:"event_types,omitempty"`
+	Origins     []string          `yaml:"origins,omitempty"`
+	Deployments []string          `yaml:"deployments,omitempty"`
+	JobPattern  string            `yaml:"job_pattern,omitempty"`
+	Tags        map[string]string `yaml:"tags,omitempty"`
+	AppGUIDs    []string          `yaml:"app_guids,omitempty"`
+}
+
+// FilterConfig is the YAML representation of a RuleFilter.
+type FilterConfig struct {
+	RuleConfig `yaml:",inline"`
+	Deny       bool `yaml:"deny,omitempty"`
+}
+
+// RouterConfig is the YAML representation of a RuleRouter.
+type RouterConfig struct {
+	RuleConfig `yaml:",inline"`
+	Sink       string `yaml:"sink"`
+}
+
+// PipelineConfig is the YAML representation of a Pipeline's filter and
+// router chains.
+type PipelineConfig struct {
+	Filters []FilterConfig `yaml:"filters,omitempty"`
+	Routers []RouterConfig `yaml:"routers,omitempty"`
+}
+
+// LoadPipelineConfig reads and parses the pipeline rules at path.
+func LoadPipelineConfig(path string) (PipelineConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return PipelineConfig{}, fmt.Errorf("pipeline: reading %s: %v", path, err)
+	}
+
+	var config PipelineConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return PipelineConfig{}, fmt.Errorf("pipeline: parsing %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// BuildPipeline compiles config into a Pipeline, resolving each router's
+// sink name against sinks. It fails fast on an unknown event type, an
+// invalid job_pattern, or a router referencing a sink that doesn't exist.
+func BuildPipeline(config PipelineConfig, sinks map[string]Sink) (*Pipeline, error) {
+	filters := make([]EnvelopeFilter, 0, len(config.Filters))
+	for _, filterConfig := range config.Filters {
+		rule, err := filterConfig.RuleConfig.toRule()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, RuleFilter{Rule: rule, Deny: filterConfig.Deny})
+	}
+
+	routers := make([]EnvelopeRouter, 0, len(config.Routers))
+	for _, routerConfig := range config.Routers {
+		rule, err := routerConfig.RuleConfig.toRule()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := sinks[routerConfig.Sink]; !ok {
+			return nil, fmt.Errorf("pipeline: router references unknown sink %q", routerConfig.Sink)
+		}
+		routers = append(routers, RuleRouter{Rule: rule, SinkName: routerConfig.Sink})
+	}
+
+	return NewPipeline(filters, routers, sinks), nil
+}
+
+func (c RuleConfig) toRule() (Rule, error) {
+	rule := Rule{
+		Origins:     c.Origins,
+		Deployments: c.Deployments,
+		Tags:        c.Tags,
+		AppGUIDs:    c.AppGUIDs,
+	}
+
+	for _, name := range c.EventTypes {
+		eventType, ok := events.Envelope_EventType_value[name]
+		if !ok {
+			return Rule{}, fmt.Errorf("pipeline: unknown event type %q", name)
+		}
+		rule.EventTypes = append(rule.EventTypes, events.Envelope_EventType(eventType))
+	}
+
+	if c.JobPattern != "" {
+		pattern, err := regexp.Compile(c.JobPattern)
+		if err != nil {
+			return Rule{}, fmt.Errorf("pipeline: compiling job_pattern %q: %v", c.JobPattern, err)
+		}
+		rule.JobPattern = pattern
+	}
+
+	return rule, nil
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nozzle
+
+import (
+	"os"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoadPipelineConfig", func() {
+	It("returns an error when the file can't be read", func() {
+		_, err := LoadPipelineConfig("/does/not/exist.yaml")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("parses a well-formed config", func() {
+		path := writeTempFile("filters: []\n")
+		defer os.Remove(path)
+
+		config, err := LoadPipelineConfig(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.Filters).To(BeEmpty())
+	})
+})
+
+var _ = Describe("BuildPipeline", func() {
+	It("fails on an unknown event_types entry", func() {
+		config := PipelineConfig{
+			Filters: []FilterConfig{{RuleConfig: RuleConfig{EventTypes: []string{"NotARealEventType"}}}},
+		}
+
+		_, err := BuildPipeline(config, map[string]Sink{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails on an invalid job_pattern regex", func() {
+		config := PipelineConfig{
+			Filters: []FilterConfig{{RuleConfig: RuleConfig{JobPattern: "("}}},
+		}
+
+		_, err := BuildPipeline(config, map[string]Sink{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails when a router references a sink that doesn't exist", func() {
+		config := PipelineConfig{
+			Routers: []RouterConfig{{Sink: "missing-sink"}},
+		}
+
+		_, err := BuildPipeline(config, map[string]Sink{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("builds a pipeline that filters and routes as configured", func() {
+		config := PipelineConfig{
+			Filters: []FilterConfig{{RuleConfig: RuleConfig{EventTypes: []string{"LogMessage"}}}},
+			Routers: []RouterConfig{{RuleConfig: RuleConfig{Origins: []string{"special-origin"}}, Sink: "special"}},
+		}
+
+		pipeline, err := BuildPipeline(config, map[string]Sink{"special": {}})
+		Expect(err).NotTo(HaveOccurred())
+
+		metricEventType := events.Envelope_ValueMetric
+		metricOrigin := "other-origin"
+		_, ok := pipeline.Evaluate(&events.Envelope{EventType: &metricEventType, Origin: &metricOrigin})
+		Expect(ok).To(BeFalse())
+
+		logEventType := events.Envelope_LogMessage
+		logOrigin := "special-origin"
+		sink, ok := pipeline.Evaluate(&events.Envelope{EventType: &logEventType, Origin: &logOrigin})
+		Expect(ok).To(BeTrue())
+		Expect(sink.isZero()).To(BeTrue())
+	})
+})
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nozzle
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pipeline", func() {
+	var (
+		logEventType    = events.Envelope_LogMessage
+		metricEventType = events.Envelope_ValueMetric
+	)
+
+	envelopeFrom := func(eventType events.Envelope_EventType, origin, job string) *events.Envelope {
+		return &events.Envelope{
+			EventType: &eventType,
+			Origin:    &origin,
+			Job:       &job,
+		}
+	}
+
+	Describe("filters", func() {
+		It("drops envelopes that don't match an allow-list rule and counts them", func() {
+			pipeline := NewPipeline(
+				[]EnvelopeFilter{RuleFilter{Rule: Rule{Origins: []string{"good-origin"}}}},
+				nil,
+				nil,
+			)
+
+			_, ok := pipeline.Evaluate(envelopeFrom(logEventType, "bad-origin", "some-job"))
+			Expect(ok).To(BeFalse())
+
+			_, ok = pipeline.Evaluate(envelopeFrom(logEventType, "good-origin", "some-job"))
+			Expect(ok).To(BeTrue())
+
+			Expect(pipeline.Metrics().Dropped).To(BeEquivalentTo(1))
+		})
+
+		It("drops envelopes that match a deny-list rule", func() {
+			pipeline := NewPipeline(
+				[]EnvelopeFilter{RuleFilter{
+					Rule: Rule{AppGUIDs: []string{"banned-app"}},
+					Deny: true,
+				}},
+				nil,
+				nil,
+			)
+
+			event := &events.LogMessage{AppId: strPtr("banned-app")}
+			envelope := &events.Envelope{EventType: &logEventType, LogMessage: event}
+
+			_, ok := pipeline.Evaluate(envelope)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("routers", func() {
+		It("routes a matching envelope to its named sink and counts the route", func() {
+			httpSink := Sink{LogHandler: &fakeLogHandler{}}
+			sinks := map[string]Sink{"http-sink": httpSink}
+
+			pipeline := NewPipeline(
+				nil,
+				[]EnvelopeRouter{RuleRouter{
+					Rule:     Rule{EventTypes: []events.Envelope_EventType{events.Envelope_HttpStartStop}},
+					SinkName: "http-sink",
+				}},
+				sinks,
+			)
+
+			eventType := events.Envelope_HttpStartStop
+			envelope := &events.Envelope{EventType: &eventType}
+
+			sink, ok := pipeline.Evaluate(envelope)
+			Expect(ok).To(BeTrue())
+			Expect(sink.LogHandler).To(BeIdenticalTo(httpSink.LogHandler))
+
+			Expect(pipeline.Metrics().RoutedTo).To(HaveKeyWithValue("http-sink", uint64(1)))
+		})
+
+		It("falls through to the Nozzle defaults when no router matches", func() {
+			pipeline := NewPipeline(
+				nil,
+				[]EnvelopeRouter{RuleRouter{
+					Rule:     Rule{EventTypes: []events.Envelope_EventType{events.Envelope_HttpStartStop}},
+					SinkName: "http-sink",
+				}},
+				map[string]Sink{"http-sink": {}},
+			)
+
+			sink, ok := pipeline.Evaluate(envelopeFrom(metricEventType, "origin", "job"))
+			Expect(ok).To(BeTrue())
+			Expect(sink.isZero()).To(BeTrue())
+		})
+
+		It("counts a filter_errors when a router names a sink that was removed", func() {
+			pipeline := NewPipeline(
+				nil,
+				[]EnvelopeRouter{RuleRouter{Rule: Rule{}, SinkName: "missing-sink"}},
+				map[string]Sink{},
+			)
+
+			_, ok := pipeline.Evaluate(envelopeFrom(logEventType, "origin", "job"))
+			Expect(ok).To(BeTrue())
+			Expect(pipeline.Metrics().FilterErrors).To(BeEquivalentTo(1))
+		})
+	})
+
+	Describe("PipelineHolder", func() {
+		It("Current returns the pipeline it was constructed with", func() {
+			initial := NewPipeline(nil, nil, nil)
+			holder := NewPipelineHolder(initial)
+
+			Expect(holder.Current()).To(BeIdenticalTo(initial))
+		})
+
+		It("Reload swaps in a newly built pipeline on success", func() {
+			initial := NewPipeline(nil, nil, nil)
+			holder := NewPipelineHolder(initial)
+
+			path := writeTempFile("filters: []\n")
+			defer os.Remove(path)
+
+			err := holder.Reload(path, map[string]Sink{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(holder.Current()).NotTo(BeIdenticalTo(initial))
+		})
+
+		It("leaves the active pipeline untouched when the config can't be read", func() {
+			initial := NewPipeline(nil, nil, nil)
+			holder := NewPipelineHolder(initial)
+
+			err := holder.Reload("/does/not/exist.yaml", map[string]Sink{})
+			Expect(err).To(HaveOccurred())
+			Expect(holder.Current()).To(BeIdenticalTo(initial))
+		})
+	})
+})
+
+func writeTempFile(contents string) string {
+	f, err := ioutil.TempFile("", "pipeline-config-*.yaml")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(contents); err != nil {
+		panic(err)
+	}
+	return f.Name()
+}
+
+type fakeLogHandler struct{}
+
+func (f *fakeLogHandler) HandleEnvelope(envelope *events.Envelope) {}